@@ -1,11 +1,47 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"net/http"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	batchTimeout         = flag.Duration("batch-timeout", 500*time.Millisecond, "deadline for collecting upstream results in /collatz/batch; computing their sequences then gets a second deadline of the same length")
+	batchUpstreamTimeout = flag.Duration("batch-upstream-timeout", 500*time.Millisecond, "per-upstream timeout for /collatz/batch requests")
+	batchMaxNumber       = flag.Int("batch-max-number", 1_000_000_000, "numbers above this reported by an upstream are dropped from /collatz/batch, to bound the cost of computing their sequences")
+	batchMaxBodyBytes    = flag.Int64("batch-max-body-bytes", 1<<20, "maximum bytes read from a single /collatz/batch upstream response body")
+	statsRangeCap        = flag.Int("stats-range-cap", 1_000_000, "maximum number of seeds permitted in a single /collatz/stats request")
+	statsMaxSeed         = flag.Int("stats-max-seed", 1_000_000_000, "maximum seed value permitted in a /collatz/stats request, to stay well clear of int64 overflow in nextCollatz")
+	statsTimeout         = flag.Duration("stats-timeout", 30*time.Second, "deadline for a /collatz/stats request's worker pool")
+	powEnabled           = flag.Bool("pow", false, "require a proof-of-work challenge for /collatz requests above -pow-threshold")
+	powThreshold         = flag.Int("pow-threshold", 1_000_000, "numbers above this require a solved PoW challenge when -pow is set")
+	powSecret            = flag.String("pow-secret", "", "HMAC secret used to sign PoW challenges (required when -pow is set)")
+	powDifficulty        = flag.String("pow-difficulty", "aaaaaa", "required hex prefix for solved PoW nonce digests")
+)
+
+const (
+	powChallengeCount = 8
+	powPrefixBytes    = 4
+	powChallengeTTL   = 30 * time.Second
 )
 
 // Response represents the API response structure
@@ -14,6 +50,440 @@ type Response struct {
 	Sequence []int `json:"sequence"`
 }
 
+// BigResponse is the response envelope for /collatz/big, the arbitrary
+// precision counterpart to Response. Terms are serialized as strings
+// since JSON numbers cannot safely carry values above 2^53.
+type BigResponse struct {
+	Number   string   `json:"number"`
+	Sequence []string `json:"sequence"`
+}
+
+// PowChallenge is the 402 body returned when a request's number exceeds
+// -pow-threshold: the caller must find a nonce per prefix whose SHA-256
+// digest starts with the configured -pow-difficulty before Expires.
+type PowChallenge struct {
+	Prefixes  []string `json:"prefixes"`
+	Expires   int64    `json:"expires"`
+	Signature string   `json:"signature"`
+}
+
+// PowSolution is the body POSTed back to redeem a PowChallenge: the
+// original challenge fields plus one nonce per prefix.
+type PowSolution struct {
+	Prefixes  []string `json:"prefixes"`
+	Nonces    []string `json:"nonces"`
+	Expires   int64    `json:"expires"`
+	Signature string   `json:"signature"`
+}
+
+// signPowChallenge computes the HMAC-SHA256 signature over the
+// challenge's prefixes, expiry, and the requested number, binding all
+// three together so a solved challenge can't be replayed against a
+// different number (cheaply solving a challenge for a small number must
+// not unlock the sequence for an arbitrarily large one).
+func signPowChallenge(secret []byte, prefixes []string, expires int64, number int) string {
+	mac := hmac.New(sha256.New, secret)
+	for _, p := range prefixes {
+		mac.Write([]byte(p))
+	}
+	fmt.Fprintf(mac, "%d:%d", expires, number)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newPowChallenge generates a fresh PowChallenge signed for number.
+func newPowChallenge(secret []byte, number int) (PowChallenge, error) {
+	prefixes := make([]string, powChallengeCount)
+	for i := range prefixes {
+		buf := make([]byte, powPrefixBytes)
+		if _, err := rand.Read(buf); err != nil {
+			return PowChallenge{}, err
+		}
+		prefixes[i] = hex.EncodeToString(buf)
+	}
+	expires := time.Now().Add(powChallengeTTL).Unix()
+	return PowChallenge{
+		Prefixes:  prefixes,
+		Expires:   expires,
+		Signature: signPowChallenge(secret, prefixes, expires, number),
+	}, nil
+}
+
+// verifyPowSolution checks that sol is an unexpired, unmodified
+// challenge issued for number whose nonces all satisfy the difficulty
+// target. It does not check whether sol has already been redeemed; see
+// redeemPowSignature for that.
+func verifyPowSolution(secret []byte, sol PowSolution, number int) error {
+	if time.Now().Unix() > sol.Expires {
+		return fmt.Errorf("challenge expired")
+	}
+	expected := signPowChallenge(secret, sol.Prefixes, sol.Expires, number)
+	if !hmac.Equal([]byte(expected), []byte(sol.Signature)) {
+		return fmt.Errorf("invalid signature")
+	}
+	if len(sol.Nonces) != len(sol.Prefixes) {
+		return fmt.Errorf("expected %d nonces, got %d", len(sol.Prefixes), len(sol.Nonces))
+	}
+	for i, prefix := range sol.Prefixes {
+		sum := sha256.Sum256([]byte(prefix + sol.Nonces[i]))
+		if !strings.HasPrefix(hex.EncodeToString(sum[:]), *powDifficulty) {
+			return fmt.Errorf("nonce %d does not satisfy the difficulty target", i)
+		}
+	}
+	return nil
+}
+
+var (
+	redeemedPowSignatures   = make(map[string]int64) // signature -> expires
+	redeemedPowSignaturesMu sync.Mutex
+)
+
+// redeemPowSignature marks sig as spent so it can't be replayed, and
+// reports whether it was unspent. Expired entries are pruned opportunistically
+// so the map doesn't grow without bound.
+func redeemPowSignature(sig string, expires int64) bool {
+	redeemedPowSignaturesMu.Lock()
+	defer redeemedPowSignaturesMu.Unlock()
+
+	now := time.Now().Unix()
+	for s, exp := range redeemedPowSignatures {
+		if exp < now {
+			delete(redeemedPowSignatures, s)
+		}
+	}
+
+	if _, spent := redeemedPowSignatures[sig]; spent {
+		return false
+	}
+	redeemedPowSignatures[sig] = expires
+	return true
+}
+
+// powGuard wraps next with a proof-of-work gate: GET requests whose
+// number exceeds threshold receive a 402 PowChallenge bound to that
+// number instead of reaching next; the solved PowSolution is POSTed back
+// to the same path, verified and redeemed here, and only then forwarded
+// to next as the GET it stands in for (next, e.g. collatzHandler, only
+// ever serves GET).
+func powGuard(next http.Handler, threshold int, secret []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		num, err := strconv.Atoi(r.URL.Query().Get("number"))
+		if err != nil || num <= threshold {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			var sol PowSolution
+			if err := json.NewDecoder(r.Body).Decode(&sol); err != nil {
+				http.Error(w, "Invalid PoW solution", http.StatusBadRequest)
+				return
+			}
+			if err := verifyPowSolution(secret, sol, num); err != nil {
+				http.Error(w, fmt.Sprintf("PoW verification failed: %v", err), http.StatusForbidden)
+				return
+			}
+			if !redeemPowSignature(sol.Signature, sol.Expires) {
+				http.Error(w, "PoW solution already redeemed", http.StatusForbidden)
+				return
+			}
+			verified := r.Clone(r.Context())
+			verified.Method = http.MethodGet
+			next.ServeHTTP(w, verified)
+			return
+		}
+
+		challenge, err := newPowChallenge(secret, num)
+		if err != nil {
+			log.Printf("Error generating PoW challenge: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPaymentRequired)
+		if err := json.NewEncoder(w).Encode(challenge); err != nil {
+			log.Printf("Error encoding PoW challenge: %v", err)
+		}
+	})
+}
+
+// SeedStats holds the analytical results for a single seed's Collatz
+// trajectory: its stopping time (sequence length minus the seed itself),
+// the highest value reached ("altitude"), and the number of steps until
+// the trajectory first drops below the seed ("glide").
+type SeedStats struct {
+	Seed         int `json:"seed"`
+	StoppingTime int `json:"stoppingTime"`
+	Altitude     int `json:"altitude"`
+	Glide        int `json:"glide"`
+}
+
+// StatsResponse is the response envelope for /collatz/stats: per-seed
+// rows plus the overall maxima and the seeds that achieved them.
+type StatsResponse struct {
+	Seeds           []SeedStats `json:"seeds"`
+	MaxStoppingTime int         `json:"maxStoppingTime"`
+	MaxStoppingSeed int         `json:"maxStoppingSeed"`
+	MaxAltitude     int         `json:"maxAltitude"`
+	MaxAltitudeSeed int         `json:"maxAltitudeSeed"`
+}
+
+// seedStats walks the Collatz trajectory of n once, computing its
+// stopping time, altitude, and glide in a single pass.
+func seedStats(n int) SeedStats {
+	stats := SeedStats{Seed: n, Altitude: n}
+	current := n
+	glideFound := false
+	for {
+		next, ok := nextCollatz(current)
+		if !ok {
+			break
+		}
+		current = next
+		stats.StoppingTime++
+		if current > stats.Altitude {
+			stats.Altitude = current
+		}
+		if !glideFound && current < n {
+			stats.Glide = stats.StoppingTime
+			glideFound = true
+		}
+	}
+	return stats
+}
+
+// statsHandler handles the /collatz/stats endpoint. It computes
+// SeedStats for every seed in [from, to] using a worker pool sized to
+// runtime.NumCPU(), bounds the range to statsRangeCap seeds and the
+// seeds themselves to statsMaxSeed (seedStats uses plain int arithmetic,
+// which would overflow and never terminate near the int64 boundary; use
+// /collatz/big for larger seeds), and supports an optional `?top=N`
+// filter that keeps only the N seeds with the longest stopping time. The
+// worker pool is bounded by statsTimeout so a pathological range can't
+// hang the request indefinitely.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fromStr := r.URL.Query().Get("from")
+	toStr := r.URL.Query().Get("to")
+	if fromStr == "" || toStr == "" {
+		http.Error(w, "Missing 'from'/'to' parameter", http.StatusBadRequest)
+		return
+	}
+
+	from, err := strconv.Atoi(fromStr)
+	if err != nil {
+		http.Error(w, "Invalid 'from' parameter", http.StatusBadRequest)
+		return
+	}
+	to, err := strconv.Atoi(toStr)
+	if err != nil {
+		http.Error(w, "Invalid 'to' parameter", http.StatusBadRequest)
+		return
+	}
+	if from <= 0 || to < from {
+		http.Error(w, "'from' must be positive and 'to' must be >= 'from'", http.StatusBadRequest)
+		return
+	}
+
+	if to > *statsMaxSeed {
+		http.Error(w, fmt.Sprintf("seeds above %d are not permitted here; use /collatz/big", *statsMaxSeed), http.StatusBadRequest)
+		return
+	}
+
+	count := to - from + 1
+	if count > *statsRangeCap {
+		http.Error(w, fmt.Sprintf("range exceeds the %d seed cap", *statsRangeCap), http.StatusBadRequest)
+		return
+	}
+
+	top := 0
+	if topStr := r.URL.Query().Get("top"); topStr != "" {
+		top, err = strconv.Atoi(topStr)
+		if err != nil || top <= 0 {
+			http.Error(w, "Invalid 'top' parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), *statsTimeout)
+	defer cancel()
+
+	seeds := make([]SeedStats, count)
+	jobs := make(chan int, count)
+	for seed := from; seed <= to; seed++ {
+		jobs <- seed
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seed := range jobs {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+				seeds[seed-from] = seedStats(seed)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		http.Error(w, "computation exceeded the stats timeout", http.StatusGatewayTimeout)
+		return
+	}
+
+	response := StatsResponse{Seeds: seeds}
+	for _, s := range seeds {
+		if s.StoppingTime > response.MaxStoppingTime {
+			response.MaxStoppingTime = s.StoppingTime
+			response.MaxStoppingSeed = s.Seed
+		}
+		if s.Altitude > response.MaxAltitude {
+			response.MaxAltitude = s.Altitude
+			response.MaxAltitudeSeed = s.Seed
+		}
+	}
+
+	if top > 0 && top < len(response.Seeds) {
+		sorted := make([]SeedStats, len(response.Seeds))
+		copy(sorted, response.Seeds)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].StoppingTime > sorted[j].StoppingTime
+		})
+		response.Seeds = sorted[:top]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding stats response: %v", err)
+	}
+}
+
+// BatchUpstream is the expected JSON body returned by each upstream URL
+// passed to /collatz/batch.
+type BatchUpstream struct {
+	Numbers []int `json:"Numbers"`
+}
+
+// BatchResponse is the response envelope for /collatz/batch: the Collatz
+// sequence for every distinct number collected from the upstreams.
+type BatchResponse struct {
+	Sequences map[int][]int `json:"sequences"`
+}
+
+// nextCollatz returns the term that follows n in the Collatz sequence and
+// whether the sequence continues. It returns ok=false once n is 1, which
+// lets /collatz and /collatz/stream share the same core logic without
+// either of them having to buffer the whole sequence up front.
+func nextCollatz(n int) (next int, ok bool) {
+	if n == 1 {
+		return 0, false
+	}
+	if n%2 == 0 {
+		return n / 2, true
+	}
+	return 3*n + 1, true
+}
+
+// Encoder converts a Response into a wire format and reports the
+// Content-Type it should be served with.
+type Encoder interface {
+	ContentType() string
+	Encode(w io.Writer, resp Response) error
+}
+
+// jsonEncoder is the original /collatz encoding.
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string { return "application/json" }
+
+func (jsonEncoder) Encode(w io.Writer, resp Response) error {
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// csvEncoder writes one row per term with an index,value header.
+type csvEncoder struct{}
+
+func (csvEncoder) ContentType() string { return "text/csv" }
+
+func (csvEncoder) Encode(w io.Writer, resp Response) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"index", "value"}); err != nil {
+		return err
+	}
+	for i, v := range resp.Sequence {
+		if err := cw.Write([]string{strconv.Itoa(i), strconv.Itoa(v)}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// gobEncoder serves the Response gob-encoded for Go clients.
+type gobEncoder struct{}
+
+func (gobEncoder) ContentType() string { return "application/gob" }
+
+func (gobEncoder) Encode(w io.Writer, resp Response) error {
+	return gob.NewEncoder(w).Encode(resp)
+}
+
+// textEncoder writes the sequence as space-separated terms.
+type textEncoder struct{}
+
+func (textEncoder) ContentType() string { return "text/plain" }
+
+func (textEncoder) Encode(w io.Writer, resp Response) error {
+	terms := make([]string, len(resp.Sequence))
+	for i, v := range resp.Sequence {
+		terms[i] = strconv.Itoa(v)
+	}
+	_, err := fmt.Fprintln(w, strings.Join(terms, " "))
+	return err
+}
+
+// encoders are the named Encoder implementations selectable via the `to`
+// query parameter or Accept header on /collatz.
+var encoders = map[string]Encoder{
+	"json": jsonEncoder{},
+	"csv":  csvEncoder{},
+	"gob":  gobEncoder{},
+	"text": textEncoder{},
+}
+
+var acceptEncoders = map[string]string{
+	"application/json": "json",
+	"text/csv":         "csv",
+	"application/gob":  "gob",
+	"text/plain":       "text",
+}
+
+// encoderFor resolves the Encoder to use for a /collatz request: the `to`
+// query parameter takes precedence, falling back to the Accept header,
+// and defaulting to JSON when neither names a known encoding.
+func encoderFor(r *http.Request) Encoder {
+	if to := r.URL.Query().Get("to"); to != "" {
+		if enc, ok := encoders[to]; ok {
+			return enc
+		}
+	}
+	if name, ok := acceptEncoders[r.Header.Get("Accept")]; ok {
+		return encoders[name]
+	}
+	return encoders["json"]
+}
+
 // calculateCollatz generates the Collatz sequence for a given number
 func calculateCollatz(n int) []int {
 	if n <= 0 {
@@ -23,12 +493,12 @@ func calculateCollatz(n int) []int {
 	sequence := []int{n}
 	current := n
 
-	for current != 1 {
-		if current%2 == 0 {
-			current = current / 2
-		} else {
-			current = 3*current + 1
+	for {
+		next, ok := nextCollatz(current)
+		if !ok {
+			break
 		}
+		current = next
 		sequence = append(sequence, current)
 	}
 
@@ -62,30 +532,306 @@ func collatzHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Calculate the sequence
-	sequence := calculateCollatz(num)
-
 	// Prepare the response
 	response := Response{
 		Number:   num,
-		Sequence: sequence,
+		Sequence: calculateCollatz(num),
+	}
+
+	// Encode into a buffer first so a failing Encoder can't leave us
+	// having already written headers or a partial body.
+	enc := encoderFor(r)
+	var buf bytes.Buffer
+	if err := enc.Encode(&buf, response); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", enc.ContentType())
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// batchCollatzHandler handles the /collatz/batch endpoint. It accepts one
+// or more `u` query parameters, each an upstream URL expected to respond
+// with a JSON body of the form {"Numbers":[...]}, and returns the Collatz
+// sequence for the deduped, sorted union of every number it collects
+// within batchTimeout. Computing those sequences gets a second, fresh
+// batchTimeout, so a response can take up to twice batchTimeout, and
+// numbers not reached by then are left out of it. Upstreams that are
+// slow, broken, or return garbage are silently dropped rather than
+// failing the request.
+func batchCollatzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	urls := r.URL.Query()["u"]
+	if len(urls) == 0 {
+		http.Error(w, "Missing 'u' parameter", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), *batchTimeout)
+	defer cancel()
+
+	results := make(chan []int, len(urls))
+	for _, u := range urls {
+		go fetchBatchNumbers(ctx, u, results)
+	}
+
+	seen := make(map[int]struct{})
+collect:
+	for range urls {
+		select {
+		case nums := <-results:
+			for _, n := range nums {
+				// Drop anything too large to bound the cost of
+				// computing its sequence below, since a malicious
+				// upstream could otherwise report a handful of huge
+				// numbers and blow well past our deadline.
+				if n <= *batchMaxNumber {
+					seen[n] = struct{}{}
+				}
+			}
+		case <-ctx.Done():
+			break collect
+		}
+	}
+
+	numbers := make([]int, 0, len(seen))
+	for n := range seen {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	// The collect loop above may have already spent the whole
+	// batchTimeout waiting out a slow upstream, so give the compute
+	// phase its own fresh deadline rather than the (possibly already
+	// expired) one above; otherwise numbers collected quickly from a
+	// healthy upstream would be dropped just because a sibling upstream
+	// was slow.
+	computeCtx, computeCancel := context.WithTimeout(r.Context(), *batchTimeout)
+	defer computeCancel()
+
+	response := BatchResponse{Sequences: make(map[int][]int, len(numbers))}
+compute:
+	for _, n := range numbers {
+		select {
+		case <-computeCtx.Done():
+			break compute
+		default:
+		}
+		response.Sequences[n] = calculateCollatz(n)
 	}
 
-	// Set response headers
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding batch response: %v", err)
+	}
+}
+
+// nextCollatzBig is the math/big counterpart to nextCollatz. It avoids the
+// overflow plain int arithmetic hits once 3*current+1 exceeds int64, e.g.
+// for math.MaxInt64, and also accepts seeds such as 27e18 that an int
+// cannot hold at all.
+func nextCollatzBig(n *big.Int) (next *big.Int, ok bool) {
+	if n.Cmp(big.NewInt(1)) == 0 {
+		return nil, false
+	}
+	next = new(big.Int)
+	if n.Bit(0) == 0 {
+		next.Rsh(n, 1)
+	} else {
+		next.Mul(n, big.NewInt(3))
+		next.Add(next, big.NewInt(1))
+	}
+	return next, true
+}
+
+// bigCollatzHandler handles the /collatz/big endpoint, the arbitrary
+// precision counterpart to /collatz for seeds that would overflow a
+// 64-bit int partway through their trajectory.
+func bigCollatzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	numStr := r.URL.Query().Get("number")
+	if numStr == "" {
+		http.Error(w, "Missing 'number' parameter", http.StatusBadRequest)
+		return
+	}
+
+	num, ok := new(big.Int).SetString(numStr, 10)
+	if !ok {
+		http.Error(w, "Invalid number format", http.StatusBadRequest)
+		return
+	}
+
+	if num.Sign() <= 0 {
+		http.Error(w, "Number must be positive", http.StatusBadRequest)
+		return
+	}
+
+	sequence := []string{num.String()}
+	current := num
+	for {
+		next, more := nextCollatzBig(current)
+		if !more {
+			break
+		}
+		current = next
+		sequence = append(sequence, current.String())
+	}
+
+	response := BigResponse{
+		Number:   num.String(),
+		Sequence: sequence,
+	}
 
-	// Encode and send the response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding response: %v", err)
+		log.Printf("Error encoding big response: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 }
 
+// streamCollatzHandler handles the /collatz/stream endpoint. Unlike
+// /collatz, it never materializes the sequence in memory: it writes each
+// term as an SSE `data:` event as soon as nextCollatz produces it, which
+// matters because for large starting values the sequence can run to
+// millions of terms. It stops early if the client disconnects, and an
+// optional `?heartbeat=Ns` parameter emits SSE comment lines every N
+// seconds to keep idle connections alive.
+func streamCollatzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	numStr := r.URL.Query().Get("number")
+	if numStr == "" {
+		http.Error(w, "Missing 'number' parameter", http.StatusBadRequest)
+		return
+	}
+
+	num, err := strconv.Atoi(numStr)
+	if err != nil {
+		http.Error(w, "Invalid number format", http.StatusBadRequest)
+		return
+	}
+
+	if num <= 0 {
+		http.Error(w, "Number must be positive", http.StatusBadRequest)
+		return
+	}
+
+	var heartbeat time.Duration
+	if hb := r.URL.Query().Get("heartbeat"); hb != "" {
+		secs, err := strconv.Atoi(hb)
+		if err != nil || secs <= 0 {
+			http.Error(w, "Invalid 'heartbeat' parameter", http.StatusBadRequest)
+			return
+		}
+		heartbeat = time.Duration(secs) * time.Second
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	var heartbeatC <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		heartbeatC = ticker.C
+	}
+
+	ctx := r.Context()
+	current := num
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeatC:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+			continue
+		default:
+		}
+
+		fmt.Fprintf(w, "data: %d\n\n", current)
+		flusher.Flush()
+
+		next, more := nextCollatz(current)
+		if !more {
+			return
+		}
+		current = next
+	}
+}
+
+// fetchBatchNumbers GETs url, decodes a BatchUpstream body, and forwards
+// its Numbers on results. Any failure (bad URL, network error, malformed
+// JSON) simply means nothing is sent for this upstream. The response body
+// is capped at batchMaxBodyBytes so a malicious or oversized upstream
+// can't exhaust server memory by streaming an unbounded body within the
+// per-upstream timeout.
+func fetchBatchNumbers(ctx context.Context, url string, results chan<- []int) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+
+	client := http.Client{Timeout: *batchUpstreamTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var body BatchUpstream
+	if err := json.NewDecoder(io.LimitReader(resp.Body, *batchMaxBodyBytes)).Decode(&body); err != nil {
+		return
+	}
+
+	select {
+	case results <- body.Numbers:
+	case <-ctx.Done():
+	}
+}
+
 func main() {
-	// Register the handler
-	http.HandleFunc("/collatz", collatzHandler)
+	flag.Parse()
+
+	var collatz http.Handler = http.HandlerFunc(collatzHandler)
+	if *powEnabled {
+		if *powSecret == "" {
+			log.Fatal("-pow requires -pow-secret")
+		}
+		collatz = powGuard(collatz, *powThreshold, []byte(*powSecret))
+	}
+
+	// Register the handlers
+	http.Handle("/collatz", collatz)
+	http.HandleFunc("/collatz/batch", batchCollatzHandler)
+	http.HandleFunc("/collatz/stream", streamCollatzHandler)
+	http.HandleFunc("/collatz/big", bigCollatzHandler)
+	http.HandleFunc("/collatz/stats", statsHandler)
 
 	// Start the server
 	port := ":9090"
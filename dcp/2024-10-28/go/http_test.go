@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchCollatzHandler(t *testing.T) {
+	stalling := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer stalling.Close()
+
+	garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not json"))
+	}))
+	defer garbage.Close()
+
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(BatchUpstream{Numbers: []int{6, 7}})
+	}))
+	defer good.Close()
+
+	origTimeout, origUpstreamTimeout := *batchTimeout, *batchUpstreamTimeout
+	*batchTimeout = 200 * time.Millisecond
+	*batchUpstreamTimeout = 100 * time.Millisecond
+	defer func() {
+		*batchTimeout = origTimeout
+		*batchUpstreamTimeout = origUpstreamTimeout
+	}()
+
+	q := url.Values{}
+	q.Add("u", stalling.URL)
+	q.Add("u", garbage.URL)
+	q.Add("u", good.URL)
+
+	req := httptest.NewRequest(http.MethodGet, "/collatz/batch?"+q.Encode(), nil)
+	w := httptest.NewRecorder()
+
+	batchCollatzHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var resp BatchResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+
+	if seq, ok := resp.Sequences[6]; !ok || len(seq) == 0 {
+		t.Errorf("expected a sequence for 6 from the healthy upstream, got %v", resp.Sequences)
+	}
+	if seq, ok := resp.Sequences[7]; !ok || len(seq) == 0 {
+		t.Errorf("expected a sequence for 7 from the healthy upstream, got %v", resp.Sequences)
+	}
+}
+
+func TestBatchCollatzHandlerMissingURLParam(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/collatz/batch", nil)
+	w := httptest.NewRecorder()
+
+	batchCollatzHandler(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+}
+
+// TestFetchBatchNumbersCapsBodySize confirms fetchBatchNumbers gives up on
+// an upstream whose response exceeds batchMaxBodyBytes instead of reading
+// the whole thing into memory.
+func TestFetchBatchNumbersCapsBodySize(t *testing.T) {
+	huge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"numbers":[`)
+		for i := 0; i < 1_000_000; i++ {
+			fmt.Fprintf(w, "%d,", i)
+		}
+		fmt.Fprint(w, "0]}")
+	}))
+	defer huge.Close()
+
+	origMaxBody := *batchMaxBodyBytes
+	*batchMaxBodyBytes = 1024
+	defer func() { *batchMaxBodyBytes = origMaxBody }()
+
+	results := make(chan []int, 1)
+	fetchBatchNumbers(context.Background(), huge.URL, results)
+
+	select {
+	case nums := <-results:
+		t.Fatalf("expected the oversized, truncated body to fail JSON decoding, got %v", nums)
+	default:
+	}
+}
+
+// TestBigCollatzHandlerOverflowSeeds runs the math/big path end to end on
+// seeds beyond int64: 27000000000000000000 starts above it, and
+// math.MaxInt64 overflows on its first 3n+1 step. The long 989345275647
+// chain is included too; it peaks at 1219624271099764, inside int64.
+func TestBigCollatzHandlerOverflowSeeds(t *testing.T) {
+	seeds := []string{
+		"989345275647",
+		"27000000000000000000",
+		"9223372036854775807", // math.MaxInt64
+	}
+
+	for _, seed := range seeds {
+		req := httptest.NewRequest(http.MethodGet, "/collatz/big?number="+seed, nil)
+		w := httptest.NewRecorder()
+
+		bigCollatzHandler(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("seed %s: expected 200, got %d: %s", seed, w.Code, w.Body.String())
+		}
+
+		var resp BigResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("seed %s: decoding response: %v", seed, err)
+		}
+
+		if resp.Number != seed {
+			t.Errorf("seed %s: expected echoed number %q, got %q", seed, seed, resp.Number)
+		}
+		if len(resp.Sequence) == 0 || resp.Sequence[len(resp.Sequence)-1] != "1" {
+			t.Errorf("seed %s: sequence did not terminate at 1: %v", seed, resp.Sequence)
+		}
+	}
+}
+
+func TestBigCollatzHandlerRejectsNonPositive(t *testing.T) {
+	for _, n := range []string{"0", "-5"} {
+		req := httptest.NewRequest(http.MethodGet, "/collatz/big?number="+n, nil)
+		w := httptest.NewRecorder()
+
+		bigCollatzHandler(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("number %s: expected 400, got %d", n, w.Code)
+		}
+	}
+}
+
+// TestCollatzHandlerEncoders hits /collatz?number=7&to=<format> for every
+// registered encoder and checks the status and Content-Type; the body of
+// each format is checked by the encoding-specific tests below.
+func TestCollatzHandlerEncoders(t *testing.T) {
+	cases := []struct {
+		to          string
+		contentType string
+	}{
+		{"json", "application/json"},
+		{"csv", "text/csv"},
+		{"gob", "application/gob"},
+		{"text", "text/plain"},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/collatz?number=7&to="+c.to, nil)
+		w := httptest.NewRecorder()
+
+		collatzHandler(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("to=%s: expected 200, got %d", c.to, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != c.contentType {
+			t.Errorf("to=%s: expected Content-Type %q, got %q", c.to, c.contentType, ct)
+		}
+	}
+}
+
+func TestCollatzHandlerCSVEncoding(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/collatz?number=7&to=csv", nil)
+	w := httptest.NewRecorder()
+
+	collatzHandler(w, req)
+
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "index,value\n") {
+		t.Errorf("expected a csv header row, got %q", body)
+	}
+	if !strings.Contains(body, "0,7\n") {
+		t.Errorf("expected the first row to be the seed itself, got %q", body)
+	}
+}
+
+func TestCollatzHandlerGobEncoding(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/collatz?number=7&to=gob", nil)
+	w := httptest.NewRecorder()
+
+	collatzHandler(w, req)
+
+	var resp Response
+	if err := gob.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding gob response: %v", err)
+	}
+	if resp.Number != 7 {
+		t.Errorf("expected number 7, got %d", resp.Number)
+	}
+}
+
+func TestCollatzHandlerAcceptHeaderFallback(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/collatz?number=7", nil)
+	req.Header.Set("Accept", "text/plain")
+	w := httptest.NewRecorder()
+
+	collatzHandler(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain" {
+		t.Errorf("expected text/plain from the Accept header, got %q", ct)
+	}
+}
+
+func TestStatsHandlerRangeCap(t *testing.T) {
+	origCap := *statsRangeCap
+	*statsRangeCap = 10
+	defer func() { *statsRangeCap = origCap }()
+
+	req := httptest.NewRequest(http.MethodGet, "/collatz/stats?from=1&to=20", nil)
+	w := httptest.NewRecorder()
+
+	statsHandler(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStatsHandlerSeedCap(t *testing.T) {
+	origMax := *statsMaxSeed
+	*statsMaxSeed = 1000
+	defer func() { *statsMaxSeed = origMax }()
+
+	req := httptest.NewRequest(http.MethodGet, "/collatz/stats?from=1&to=2000", nil)
+	w := httptest.NewRecorder()
+
+	statsHandler(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStatsHandlerTimeoutExceeded(t *testing.T) {
+	origTimeout := *statsTimeout
+	*statsTimeout = time.Nanosecond
+	defer func() { *statsTimeout = origTimeout }()
+
+	req := httptest.NewRequest(http.MethodGet, "/collatz/stats?from=1&to=1000", nil)
+	w := httptest.NewRecorder()
+
+	statsHandler(w, req)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected 504, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStatsHandlerTopFilter(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/collatz/stats?from=1&to=20&top=3", nil)
+	w := httptest.NewRecorder()
+
+	statsHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp StatsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+
+	if len(resp.Seeds) != 3 {
+		t.Fatalf("expected 3 seeds with top=3, got %d", len(resp.Seeds))
+	}
+	for i := 1; i < len(resp.Seeds); i++ {
+		if resp.Seeds[i].StoppingTime > resp.Seeds[i-1].StoppingTime {
+			t.Errorf("expected seeds sorted by descending stopping time, got %+v", resp.Seeds)
+		}
+	}
+}
+
+// mineNonce brute-forces a nonce whose SHA-256 digest over prefix+nonce
+// starts with *powDifficulty. Tests drop the difficulty to a single hex
+// character so this stays fast.
+func mineNonce(t *testing.T, prefix string) string {
+	t.Helper()
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		sum := sha256.Sum256([]byte(prefix + nonce))
+		if strings.HasPrefix(hex.EncodeToString(sum[:]), *powDifficulty) {
+			return nonce
+		}
+	}
+}
+
+func solvePowChallenge(t *testing.T, ch PowChallenge) PowSolution {
+	t.Helper()
+	nonces := make([]string, len(ch.Prefixes))
+	for i, prefix := range ch.Prefixes {
+		nonces[i] = mineNonce(t, prefix)
+	}
+	return PowSolution{Prefixes: ch.Prefixes, Nonces: nonces, Expires: ch.Expires, Signature: ch.Signature}
+}
+
+// TestPowGuard covers the full challenge/response cycle, including the
+// redemption path that used to 405 because the verified POST was
+// forwarded straight to collatzHandler without becoming a GET.
+func TestPowGuard(t *testing.T) {
+	origDifficulty := *powDifficulty
+	*powDifficulty = "0" // keep mining fast in tests
+	defer func() { *powDifficulty = origDifficulty }()
+
+	secret := []byte("test-secret")
+	threshold := 100
+	guarded := powGuard(http.HandlerFunc(collatzHandler), threshold, secret)
+
+	t.Run("numbers at or below the threshold bypass the guard", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/collatz?number=10", nil)
+		w := httptest.NewRecorder()
+		guarded.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/collatz?number=1000", nil)
+	w := httptest.NewRecorder()
+	guarded.ServeHTTP(w, req)
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d", w.Code)
+	}
+
+	var challenge PowChallenge
+	if err := json.NewDecoder(w.Body).Decode(&challenge); err != nil {
+		t.Fatalf("decoding challenge: %v", err)
+	}
+
+	var solvedBody []byte
+	t.Run("a valid solution is redeemed and the sequence is returned", func(t *testing.T) {
+		sol := solvePowChallenge(t, challenge)
+		solvedBody, _ = json.Marshal(sol)
+		req := httptest.NewRequest(http.MethodPost, "/collatz?number=1000", bytes.NewReader(solvedBody))
+		w := httptest.NewRecorder()
+		guarded.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp Response
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		if resp.Number != 1000 {
+			t.Errorf("expected number 1000, got %d", resp.Number)
+		}
+	})
+
+	t.Run("a redeemed solution cannot be replayed against the same number", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/collatz?number=1000", bytes.NewReader(solvedBody))
+		w := httptest.NewRecorder()
+		guarded.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("a solution cannot be replayed against a different, more expensive number", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/collatz?number=999999999", bytes.NewReader(solvedBody))
+		w := httptest.NewRecorder()
+		guarded.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("an expired challenge is rejected", func(t *testing.T) {
+		expired := challenge
+		expired.Expires = time.Now().Add(-time.Minute).Unix()
+		expired.Signature = signPowChallenge(secret, expired.Prefixes, expired.Expires, 1000)
+		sol := solvePowChallenge(t, expired)
+		body, _ := json.Marshal(sol)
+		req := httptest.NewRequest(http.MethodPost, "/collatz?number=1000", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		guarded.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("a tampered signature is rejected", func(t *testing.T) {
+		sol := solvePowChallenge(t, challenge)
+		sol.Signature = "deadbeef"
+		body, _ := json.Marshal(sol)
+		req := httptest.NewRequest(http.MethodPost, "/collatz?number=1000", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		guarded.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("nonces that don't meet the difficulty target are rejected", func(t *testing.T) {
+		sol := solvePowChallenge(t, challenge)
+
+		// Raise the bar after mining so the already-found nonces no
+		// longer qualify, deterministically exercising the "insufficient
+		// collisions" rejection instead of relying on a hand-picked
+		// nonce that happens not to collide.
+		*powDifficulty = "aaaaaa"
+		defer func() { *powDifficulty = "0" }()
+
+		body, _ := json.Marshal(sol)
+		req := httptest.NewRequest(http.MethodPost, "/collatz?number=1000", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		guarded.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", w.Code)
+		}
+	})
+}
+
+// parseSSEData extracts the integer payload of every SSE "data:" event in
+// body, in order, ignoring comment lines such as heartbeats.
+func parseSSEData(t *testing.T, body string) []int {
+	t.Helper()
+	var values []int
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		v, err := strconv.Atoi(strings.TrimPrefix(line, "data: "))
+		if err != nil {
+			t.Fatalf("malformed data line %q: %v", line, err)
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+func TestStreamCollatzHandlerSSEFraming(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/collatz/stream?number=6", nil)
+	w := httptest.NewRecorder()
+
+	streamCollatzHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected text/event-stream, got %q", ct)
+	}
+
+	got := parseSSEData(t, w.Body.String())
+	want := []int{6, 3, 10, 5, 16, 8, 4, 2, 1}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d events, got %d: %v", len(want), len(got), got)
+	}
+	for i, v := range want {
+		if got[i] != v {
+			t.Errorf("event %d: expected %d, got %d", i, v, got[i])
+		}
+	}
+}
+
+func TestStreamCollatzHandlerContextAlreadyCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req := httptest.NewRequest(http.MethodGet, "/collatz/stream?number=6", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	streamCollatzHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 (headers are written before the context is checked), got %d", w.Code)
+	}
+	if got := parseSSEData(t, w.Body.String()); len(got) != 0 {
+		t.Errorf("expected no events once the context is already canceled, got %v", got)
+	}
+}
+
+// cancelAfterWriter cancels its associated context partway through the
+// response body, simulating a client that disconnects mid-stream.
+type cancelAfterWriter struct {
+	*httptest.ResponseRecorder
+	after  int
+	writes int
+	cancel context.CancelFunc
+}
+
+func (w *cancelAfterWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseRecorder.Write(p)
+	w.writes++
+	if w.writes == w.after {
+		w.cancel()
+	}
+	return n, err
+}
+
+func TestStreamCollatzHandlerCancellationMidStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodGet, "/collatz/stream?number=6", nil).WithContext(ctx)
+	w := &cancelAfterWriter{ResponseRecorder: httptest.NewRecorder(), after: 3, cancel: cancel}
+
+	streamCollatzHandler(w, req)
+
+	got := parseSSEData(t, w.Body.String())
+	if len(got) >= 9 {
+		t.Errorf("expected the stream to stop short of the full 9-event sequence once canceled, got %v", got)
+	}
+	if len(got) == 0 {
+		t.Errorf("expected at least one event before cancellation took effect")
+	}
+}
+
+// slowFirstWriteRecorder sleeps on its first Write so a short heartbeat
+// interval elapses while the handler is "inside" that write, giving the
+// ticker a chance to fire before the next select is evaluated.
+type slowFirstWriteRecorder struct {
+	*httptest.ResponseRecorder
+	slept bool
+}
+
+func (w *slowFirstWriteRecorder) Write(p []byte) (int, error) {
+	if !w.slept {
+		w.slept = true
+		time.Sleep(1200 * time.Millisecond)
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestStreamCollatzHandlerHeartbeat(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/collatz/stream?number=6&heartbeat=1", nil)
+	w := &slowFirstWriteRecorder{ResponseRecorder: httptest.NewRecorder()}
+
+	streamCollatzHandler(w, req)
+
+	body := w.Body.String()
+	if !strings.Contains(body, ": heartbeat\n\n") {
+		t.Errorf("expected a heartbeat comment line, got %q", body)
+	}
+	got := parseSSEData(t, body)
+	want := []int{6, 3, 10, 5, 16, 8, 4, 2, 1}
+	if len(got) != len(want) {
+		t.Fatalf("expected the full sequence despite the heartbeat, got %v", got)
+	}
+}
+
+func TestStreamCollatzHandlerInvalidHeartbeat(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/collatz/stream?number=6&heartbeat=-1", nil)
+	w := httptest.NewRecorder()
+
+	streamCollatzHandler(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+}